@@ -0,0 +1,125 @@
+// Package address holds country address-format descriptors - required
+// fields, postal-code patterns and a rendering layout - patterned after
+// libaddressinput/Boostport-address's country data, so ReadShapefile can
+// validate records and synthesize a single-line "addr:full" tag without
+// hard-coding Slovenian assumptions inline.
+package address
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Format describes one country's address rules. Layout uses the same
+// placeholder convention as libaddressinput: %N housenumber, %S street (or
+// place, when there is no street), %Z postcode, %C city.
+type Format struct {
+	RegionCode      string
+	RequiredFields  []string
+	PostalCodeRegex *regexp.Regexp
+	Layout          string
+}
+
+// formats is keyed by ISO 3166-1 alpha-2 region code. Additional countries
+// can be dropped in here without touching the validation/rendering code
+// below.
+var formats = map[string]*Format{
+	"SI": {
+		RegionCode:      "SI",
+		RequiredFields:  []string{"housenumber", "street|place", "postcode", "city"},
+		PostalCodeRegex: regexp.MustCompile(`^[0-9]{4}$`),
+		Layout:          "%S %N\n%Z %C",
+	},
+}
+
+// Record is the set of fields a Format validates and renders against.
+type Record struct {
+	Housenumber string
+	Street      string
+	Place       string
+	Postcode    string
+	City        string
+}
+
+func hasField(rec Record, field string) bool {
+	switch field {
+	case "housenumber":
+		return rec.Housenumber != ""
+	case "street|place":
+		return rec.Street != "" || rec.Place != ""
+	case "postcode":
+		return rec.Postcode != ""
+	case "city":
+		return rec.City != ""
+	default:
+		return true
+	}
+}
+
+// Validate returns "" when rec satisfies the required fields and
+// postal-code pattern registered for regionCode, otherwise a short
+// human-readable reason suitable for logging.
+func Validate(regionCode string, rec Record) string {
+	f, ok := formats[regionCode]
+	if !ok {
+		return fmt.Sprintf("no address format registered for region %q", regionCode)
+	}
+
+	for _, field := range f.RequiredFields {
+		if !hasField(rec, field) {
+			return fmt.Sprintf("missing required field %q", field)
+		}
+	}
+
+	if f.PostalCodeRegex != nil && !f.PostalCodeRegex.MatchString(rec.Postcode) {
+		return fmt.Sprintf("postcode %q does not match %s", rec.Postcode, f.PostalCodeRegex.String())
+	}
+
+	return ""
+}
+
+// Render formats rec as a single-line address using regionCode's layout.
+// It returns "" if regionCode isn't registered.
+func Render(regionCode string, rec Record) string {
+	return RenderLayout(SelectLayout(regionCode), rec)
+}
+
+// RenderLayout formats rec using an explicit layout string, as returned by
+// SelectLayout/SelectLocalLayout, substituting %S/%N/%Z/%C and collapsing
+// the result onto a single line (addr:full has no room for Format's
+// multi-line postal layout).
+func RenderLayout(layout string, rec Record) string {
+	if layout == "" {
+		return ""
+	}
+
+	streetOrPlace := rec.Street
+	if streetOrPlace == "" {
+		streetOrPlace = rec.Place
+	}
+
+	replacer := strings.NewReplacer(
+		"%S", streetOrPlace,
+		"%N", rec.Housenumber,
+		"%Z", rec.Postcode,
+		"%C", rec.City,
+	)
+	return strings.Join(strings.Fields(replacer.Replace(layout)), " ")
+}
+
+// SelectLayout returns the registered layout for regionCode, or "" if none
+// is registered.
+func SelectLayout(regionCode string) string {
+	if f, ok := formats[regionCode]; ok {
+		return f.Layout
+	}
+	return ""
+}
+
+// SelectLocalLayout returns the Slovenian layout, the only region this tool
+// has ever imported data for. Bilingual records render through the same
+// layout; only the field values (sl vs. it/hu names) differ.
+func SelectLocalLayout() string {
+	return SelectLayout("SI")
+}
@@ -0,0 +1,48 @@
+package address
+
+import "testing"
+
+func TestValidateRejectsMissingStreetAndPlace(t *testing.T) {
+	rec := Record{Housenumber: "12", Postcode: "1000", City: "Ljubljana"}
+	if reason := Validate("SI", rec); reason == "" {
+		t.Error("Validate accepted a record with neither street nor place")
+	}
+}
+
+func TestValidateRejectsBadPostcode(t *testing.T) {
+	rec := Record{Housenumber: "12", Street: "Slovenska cesta", Postcode: "100", City: "Ljubljana"}
+	if reason := Validate("SI", rec); reason == "" {
+		t.Error("Validate accepted a 3-digit postcode")
+	}
+}
+
+func TestValidateAcceptsCompleteRecord(t *testing.T) {
+	rec := Record{Housenumber: "12", Street: "Slovenska cesta", Postcode: "1000", City: "Ljubljana"}
+	if reason := Validate("SI", rec); reason != "" {
+		t.Errorf("Validate rejected a complete record: %s", reason)
+	}
+}
+
+func TestValidateUnknownRegion(t *testing.T) {
+	if reason := Validate("XX", Record{}); reason == "" {
+		t.Error("Validate accepted an unregistered region code")
+	}
+}
+
+func TestRenderLayoutPrefersStreetOverPlace(t *testing.T) {
+	rec := Record{Housenumber: "12", Street: "Slovenska cesta", Place: "Fallback", Postcode: "1000", City: "Ljubljana"}
+	got := RenderLayout(SelectLocalLayout(), rec)
+	want := "Slovenska cesta 12 1000 Ljubljana"
+	if got != want {
+		t.Errorf("RenderLayout() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderLayoutFallsBackToPlace(t *testing.T) {
+	rec := Record{Housenumber: "5", Place: "Bled", Postcode: "4260", City: "Bled"}
+	got := RenderLayout(SelectLocalLayout(), rec)
+	want := "Bled 5 4260 Bled"
+	if got != want {
+		t.Errorf("RenderLayout() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,346 @@
+// Package conflate compares a GURS address FeatureCollection against an
+// existing OpenStreetMap extract and sorts the result into three buckets:
+// addresses that are new to OSM, addresses that already exist but whose
+// tags have drifted, and previously-imported addresses that have since
+// disappeared from the GURS source and should be retired.
+package conflate
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/paulmach/go.geojson"
+	"github.com/qedus/osmpbf"
+)
+
+const (
+	tagRef         = "ref:GURS:HS_MID"
+	tagSource      = "source:addr"
+	tagSourceValue = "GURS"
+	tagHousenumber = "addr:housenumber"
+	tagStreet      = "addr:street"
+	tagPlace       = "addr:place"
+	tagPostCode    = "addr:postcode"
+
+	// matchRadiusMeters is the nearest-neighbor fallback radius used once a
+	// feature has neither a ref:GURS:HS_MID match nor an exact tuple match.
+	matchRadiusMeters = 25.0
+)
+
+// OSMAddress is the subset of an OSM node that conflation cares about: its
+// id and version (needed to emit a valid <modify>/<delete> later on), its
+// position and its address-related tags.
+type OSMAddress struct {
+	ID      int64
+	Version int
+	Lat     float64
+	Lon     float64
+	Tags    map[string]string
+}
+
+// Result groups the three GeoJSON outputs produced by Conflate.
+type Result struct {
+	New    *geojson.FeatureCollection
+	Update *geojson.FeatureCollection
+	Retire *geojson.FeatureCollection
+}
+
+// indexedAddress adapts an OSMAddress for storage in an rtreego.Rtree, and
+// remembers whether it was claimed by a GURS feature so leftovers can be
+// retired.
+type indexedAddress struct {
+	addr    OSMAddress
+	claimed bool
+}
+
+func (a *indexedAddress) Bounds() rtreego.Rect {
+	// A degenerate (point) rectangle; rtreego requires non-zero side
+	// lengths, so pad by a tiny epsilon.
+	const eps = 1e-9
+	rect, err := rtreego.NewRect(rtreego.Point{a.addr.Lon, a.addr.Lat}, []float64{eps, eps})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return rect
+}
+
+// LoadOSMXML reads a .osm.xml extract and returns the nodes that already
+// carry our addr:housenumber tag, keyed by nothing in particular - callers
+// build whatever index they need from the returned slice.
+func LoadOSMXML(filename string) []OSMAddress {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	type osmTag struct {
+		K string `xml:"k,attr"`
+		V string `xml:"v,attr"`
+	}
+	type osmNode struct {
+		ID      int64    `xml:"id,attr"`
+		Version int      `xml:"version,attr"`
+		Lat     float64  `xml:"lat,attr"`
+		Lon     float64  `xml:"lon,attr"`
+		Tag     []osmTag `xml:"tag"`
+	}
+
+	var addresses []OSMAddress
+	decoder := xml.NewDecoder(f)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "node" {
+			continue
+		}
+
+		var node osmNode
+		if err := decoder.DecodeElement(&node, &start); err != nil {
+			log.Fatal(err)
+		}
+
+		tags := make(map[string]string, len(node.Tag))
+		for _, t := range node.Tag {
+			tags[t.K] = t.V
+		}
+		if _, hasHousenumber := tags[tagHousenumber]; !hasHousenumber {
+			continue
+		}
+
+		addresses = append(addresses, OSMAddress{ID: node.ID, Version: node.Version, Lat: node.Lat, Lon: node.Lon, Tags: tags})
+	}
+
+	log.Printf("%s: read %d addressed nodes", filename, len(addresses))
+	return addresses
+}
+
+// LoadOSMPBF reads a .osm.pbf extract and returns the addressed nodes it
+// contains, same contract as LoadOSMXML.
+func LoadOSMPBF(filename string) []OSMAddress {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	decoder := osmpbf.NewDecoder(f)
+	if err := decoder.Start(runtime.GOMAXPROCS(-1)); err != nil {
+		log.Fatal(err)
+	}
+
+	var addresses []OSMAddress
+	for {
+		entity, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		node, ok := entity.(*osmpbf.Node)
+		if !ok {
+			continue
+		}
+		if _, hasHousenumber := node.Tags[tagHousenumber]; !hasHousenumber {
+			continue
+		}
+
+		addresses = append(addresses, OSMAddress{ID: node.ID, Version: int(node.Info.Version), Lat: node.Lat, Lon: node.Lon, Tags: node.Tags})
+	}
+
+	log.Printf("%s: read %d addressed nodes", filename, len(addresses))
+	return addresses
+}
+
+// tupleKey normalizes (housenumber, street|place, postcode) into a single
+// string for the fallback match pass, so surrounding whitespace or differing
+// case doesn't cause two identical addresses to miss each other.
+func tupleKey(housenumber, streetOrPlace, postcode string) string {
+	return strings.ToLower(strings.TrimSpace(housenumber)) + "|" +
+		strings.ToLower(strings.TrimSpace(streetOrPlace)) + "|" +
+		strings.TrimSpace(postcode)
+}
+
+func featureTupleKey(f *geojson.Feature) string {
+	street, _ := f.Properties[tagStreet].(string)
+	place, _ := f.Properties[tagPlace].(string)
+	housenumber, _ := f.Properties[tagHousenumber].(string)
+	postcode, _ := f.Properties[tagPostCode].(string)
+
+	streetOrPlace := street
+	if streetOrPlace == "" {
+		streetOrPlace = place
+	}
+	return tupleKey(housenumber, streetOrPlace, postcode)
+}
+
+func addressTupleKey(a OSMAddress) string {
+	streetOrPlace := a.Tags[tagStreet]
+	if streetOrPlace == "" {
+		streetOrPlace = a.Tags[tagPlace]
+	}
+	return tupleKey(a.Tags[tagHousenumber], streetOrPlace, a.Tags[tagPostCode])
+}
+
+// tagsDiffer reports whether any OSM-relevant tag present on the GURS
+// feature has a different value on the OSM address (missing OSM tags don't
+// count, so manually added tags are left alone).
+func tagsDiffer(f *geojson.Feature, a OSMAddress) bool {
+	for key, value := range f.Properties {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if existing, present := a.Tags[key]; present && existing != str {
+			return true
+		}
+	}
+	return false
+}
+
+// haversineMeters returns the great-circle distance between two points, in
+// meters, used for the nearest-neighbor fallback match.
+func haversineMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusMeters = 6371000.0
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadiusMeters * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Conflate matches gurs (the freshly-read GURS FeatureCollection, with
+// [lon, lat] coordinates as produced by ReadShapefile) against osm (the
+// addressed nodes of an existing extract) and splits the result into new,
+// update and retire buckets.
+//
+// Matching is tried in order: ref:GURS:HS_MID, then the normalized
+// (housenumber, street|place, postcode) tuple, then nearest OSM address
+// node within matchRadiusMeters.
+func Conflate(gurs *geojson.FeatureCollection, osm []OSMAddress) *Result {
+	result := &Result{
+		New:    geojson.NewFeatureCollection(),
+		Update: geojson.NewFeatureCollection(),
+		Retire: geojson.NewFeatureCollection(),
+	}
+
+	byRef := make(map[string]*indexedAddress, len(osm))
+	byTuple := make(map[string]*indexedAddress, len(osm))
+	tree := rtreego.NewTree(2, 25, 50)
+	indexed := make([]*indexedAddress, len(osm))
+
+	for i, a := range osm {
+		ia := &indexedAddress{addr: a}
+		indexed[i] = ia
+
+		if ref := a.Tags[tagRef]; ref != "" {
+			byRef[ref] = ia
+		}
+		byTuple[addressTupleKey(a)] = ia
+		tree.Insert(ia)
+	}
+
+	for _, f := range gurs.Features {
+		ref, _ := f.Properties[tagRef].(string)
+
+		match := byRef[ref]
+		if match != nil && match.claimed {
+			// Already claimed by an earlier GURS feature, through any tier;
+			// fall through to the next tier instead of double-mapping
+			// two distinct addresses onto one OSM node.
+			match = nil
+		}
+		if match == nil {
+			match = byTuple[featureTupleKey(f)]
+			if match != nil && match.claimed {
+				match = nil
+			}
+		}
+		if match == nil {
+			lon, lat := f.Geometry.Point[0], f.Geometry.Point[1]
+			match = nearestWithin(tree, lat, lon, matchRadiusMeters)
+		}
+
+		if match == nil {
+			result.New.AddFeature(f)
+			continue
+		}
+
+		match.claimed = true
+		if tagsDiffer(f, match.addr) {
+			// "@id" follows the osmtogeojson convention so downstream
+			// tooling (and our own osmChange writer) knows which existing
+			// OSM node to modify, without polluting the OSM tags themselves.
+			// "@version" travels alongside it because the OSM API rejects a
+			// <modify>/<delete> whose version doesn't match the node's
+			// current one.
+			f.SetProperty("@id", fmt.Sprintf("node/%d", match.addr.ID))
+			f.SetProperty("@version", match.addr.Version)
+			result.Update.AddFeature(f)
+		}
+	}
+
+	for _, ia := range indexed {
+		if ia.claimed || ia.addr.Tags[tagSource] != tagSourceValue {
+			continue
+		}
+		retired := geojson.NewPointFeature([]float64{ia.addr.Lon, ia.addr.Lat})
+		for k, v := range ia.addr.Tags {
+			retired.SetProperty(k, v)
+		}
+		retired.SetProperty("@id", fmt.Sprintf("node/%d", ia.addr.ID))
+		retired.SetProperty("@version", ia.addr.Version)
+		result.Retire.AddFeature(retired)
+	}
+
+	return result
+}
+
+func nearestWithin(tree *rtreego.Rtree, lat, lon, radiusMeters float64) *indexedAddress {
+	// One degree of longitude shrinks with latitude (by a factor of
+	// cos(lat)); over-fetch candidates from a generous bounding box in
+	// degrees, then filter with a real haversine distance. Using the same
+	// margin for both axes would under-size the east/west half of the box
+	// at any latitude away from the equator, dropping real candidates
+	// before they ever reach the haversine check below.
+	const metersPerDegree = 111000.0
+	latMargin := radiusMeters / metersPerDegree
+	lonMargin := radiusMeters / (metersPerDegree * math.Cos(lat*math.Pi/180))
+
+	bbox, err := rtreego.NewRect(rtreego.Point{lon - lonMargin, lat - latMargin}, []float64{2 * lonMargin, 2 * latMargin})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var best *indexedAddress
+	bestDistance := math.Inf(1)
+	for _, spatial := range tree.SearchIntersect(bbox) {
+		ia := spatial.(*indexedAddress)
+		if ia.claimed {
+			continue
+		}
+		d := haversineMeters(lat, lon, ia.addr.Lat, ia.addr.Lon)
+		if d <= radiusMeters && d < bestDistance {
+			best, bestDistance = ia, d
+		}
+	}
+	return best
+}
@@ -0,0 +1,255 @@
+package conflate
+
+import (
+	"math"
+	"testing"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/paulmach/go.geojson"
+)
+
+func TestHaversineMetersZero(t *testing.T) {
+	d := haversineMeters(46.0569, 14.5058, 46.0569, 14.5058)
+	if d != 0 {
+		t.Errorf("haversineMeters of identical points = %v, want 0", d)
+	}
+}
+
+func TestHaversineMetersKnownDistance(t *testing.T) {
+	// Roughly 1 degree of latitude apart, which is ~111.2 km everywhere.
+	d := haversineMeters(46.0, 14.5, 47.0, 14.5)
+	if math.Abs(d-111195) > 500 {
+		t.Errorf("haversineMeters(46,14.5, 47,14.5) = %v, want ~111195m", d)
+	}
+}
+
+func TestNearestWithinFindsCloseCandidateEastWest(t *testing.T) {
+	// A candidate due east, 20m away at ~46N - within matchRadiusMeters but
+	// outside a naive (lat-only-scaled) bounding box, see chunk0-1's
+	// nearestWithin cos(lat) fix.
+	const lat = 46.0
+	const lon = 14.5
+
+	metersPerDegreeLon := 111000.0 * math.Cos(lat*math.Pi/180)
+	candidateLon := lon + 20.0/metersPerDegreeLon
+
+	tree := rtreego.NewTree(2, 25, 50)
+	candidate := &indexedAddress{addr: OSMAddress{ID: 1, Lat: lat, Lon: candidateLon}}
+	tree.Insert(candidate)
+
+	match := nearestWithin(tree, lat, lon, matchRadiusMeters)
+	if match == nil {
+		t.Fatal("nearestWithin did not find a candidate 20m due east, within the 25m radius")
+	}
+	if match.addr.ID != 1 {
+		t.Errorf("nearestWithin matched id %d, want 1", match.addr.ID)
+	}
+}
+
+func TestNearestWithinRejectsBeyondRadius(t *testing.T) {
+	const lat, lon = 46.0, 14.5
+
+	tree := rtreego.NewTree(2, 25, 50)
+	tree.Insert(&indexedAddress{addr: OSMAddress{ID: 1, Lat: lat + 1, Lon: lon}})
+
+	if match := nearestWithin(tree, lat, lon, matchRadiusMeters); match != nil {
+		t.Errorf("nearestWithin matched an address ~111km away, want no match")
+	}
+}
+
+func gursFeature(lat, lon float64, props map[string]string) *geojson.Feature {
+	f := geojson.NewPointFeature([]float64{lon, lat})
+	for k, v := range props {
+		f.SetProperty(k, v)
+	}
+	return f
+}
+
+func TestConflateRefMatchNoDiffDropped(t *testing.T) {
+	gurs := geojson.NewFeatureCollection()
+	gurs.AddFeature(gursFeature(46.0, 14.5, map[string]string{
+		tagRef:         "111",
+		tagHousenumber: "12",
+		tagStreet:      "Slovenska cesta",
+		tagPostCode:    "1000",
+	}))
+
+	osm := []OSMAddress{
+		{ID: 999, Version: 3, Lat: 46.0, Lon: 14.5, Tags: map[string]string{
+			tagRef:         "111",
+			tagHousenumber: "12",
+			tagStreet:      "Slovenska cesta",
+			tagPostCode:    "1000",
+		}},
+	}
+
+	result := Conflate(gurs, osm)
+	if len(result.New.Features) != 0 {
+		t.Errorf("New = %d features, want 0", len(result.New.Features))
+	}
+	if len(result.Update.Features) != 0 {
+		t.Errorf("Update = %d features, want 0 (no tag diff)", len(result.Update.Features))
+	}
+	if len(result.Retire.Features) != 0 {
+		t.Errorf("Retire = %d features, want 0 (node was claimed)", len(result.Retire.Features))
+	}
+}
+
+func TestConflateRefMatchWithDiffUpdates(t *testing.T) {
+	gurs := geojson.NewFeatureCollection()
+	gurs.AddFeature(gursFeature(46.0, 14.5, map[string]string{
+		tagRef:         "111",
+		tagHousenumber: "12",
+		tagStreet:      "Nova cesta",
+		tagPostCode:    "1000",
+	}))
+
+	osm := []OSMAddress{
+		{ID: 999, Version: 3, Lat: 46.0, Lon: 14.5, Tags: map[string]string{
+			tagRef:         "111",
+			tagHousenumber: "12",
+			tagStreet:      "Slovenska cesta",
+			tagPostCode:    "1000",
+		}},
+	}
+
+	result := Conflate(gurs, osm)
+	if len(result.Update.Features) != 1 {
+		t.Fatalf("Update = %d features, want 1", len(result.Update.Features))
+	}
+	f := result.Update.Features[0]
+	if id, _ := f.Properties["@id"].(string); id != "node/999" {
+		t.Errorf("@id = %q, want node/999", id)
+	}
+	if version, _ := f.Properties["@version"].(int); version != 3 {
+		t.Errorf("@version = %v, want 3", f.Properties["@version"])
+	}
+}
+
+func TestConflateTupleMatch(t *testing.T) {
+	gurs := geojson.NewFeatureCollection()
+	gurs.AddFeature(gursFeature(46.0, 14.5, map[string]string{
+		tagHousenumber: "12",
+		tagStreet:      "Slovenska cesta",
+		tagPostCode:    "1000",
+	}))
+
+	osm := []OSMAddress{
+		{ID: 999, Version: 1, Lat: 46.0, Lon: 14.5, Tags: map[string]string{
+			tagHousenumber: "12",
+			tagStreet:      "Slovenska cesta",
+			tagPostCode:    "1000",
+		}},
+	}
+
+	result := Conflate(gurs, osm)
+	if len(result.New.Features) != 0 {
+		t.Errorf("New = %d features, want 0 (matched by tuple)", len(result.New.Features))
+	}
+	if len(result.Update.Features) != 0 {
+		t.Errorf("Update = %d features, want 0 (no tag diff)", len(result.Update.Features))
+	}
+}
+
+func TestConflateNearestNeighborMatch(t *testing.T) {
+	const lat, lon = 46.0, 14.5
+	metersPerDegreeLon := 111000.0 * math.Cos(lat*math.Pi/180)
+	osmLon := lon + 20.0/metersPerDegreeLon
+
+	gurs := geojson.NewFeatureCollection()
+	gurs.AddFeature(gursFeature(lat, lon, map[string]string{
+		tagHousenumber: "12",
+		tagStreet:      "Slovenska cesta",
+		tagPostCode:    "1000",
+	}))
+
+	osm := []OSMAddress{
+		{ID: 999, Version: 1, Lat: lat, Lon: osmLon, Tags: map[string]string{
+			tagHousenumber: "12a",
+			tagStreet:      "Slovenska cesta",
+			tagPostCode:    "1000",
+		}},
+	}
+
+	result := Conflate(gurs, osm)
+	if len(result.New.Features) != 0 {
+		t.Errorf("New = %d features, want 0 (matched by NN)", len(result.New.Features))
+	}
+	if len(result.Update.Features) != 1 {
+		t.Fatalf("Update = %d features, want 1 (housenumber differs)", len(result.Update.Features))
+	}
+	if id, _ := result.Update.Features[0].Properties["@id"].(string); id != "node/999" {
+		t.Errorf("@id = %q, want node/999", id)
+	}
+}
+
+func TestConflateRetiresUnclaimedOSMOnlyNode(t *testing.T) {
+	gurs := geojson.NewFeatureCollection()
+
+	osm := []OSMAddress{
+		{ID: 999, Version: 1, Lat: 46.0, Lon: 14.5, Tags: map[string]string{
+			tagSource:      tagSourceValue,
+			tagHousenumber: "12",
+			tagStreet:      "Slovenska cesta",
+			tagPostCode:    "1000",
+		}},
+	}
+
+	result := Conflate(gurs, osm)
+	if len(result.Retire.Features) != 1 {
+		t.Fatalf("Retire = %d features, want 1", len(result.Retire.Features))
+	}
+	if id, _ := result.Retire.Features[0].Properties["@id"].(string); id != "node/999" {
+		t.Errorf("@id = %q, want node/999", id)
+	}
+}
+
+func TestConflateDoesNotDoubleMapClaimedNode(t *testing.T) {
+	// Regression test for a bug where a GURS feature matching by ref and a
+	// second, distinct GURS feature matching the very same OSM node by tuple
+	// (because it shares the same housenumber/street/postcode) both claimed
+	// that node, silently merging two real-world addresses onto one OSM id.
+	gurs := geojson.NewFeatureCollection()
+	gurs.AddFeature(gursFeature(46.0, 14.5, map[string]string{
+		tagRef:         "111",
+		tagHousenumber: "12",
+		tagStreet:      "Slovenska cesta",
+		tagPostCode:    "1000",
+	}))
+	gurs.AddFeature(gursFeature(46.0, 14.5, map[string]string{
+		tagRef:         "222",
+		tagHousenumber: "12",
+		tagStreet:      "Slovenska cesta",
+		tagPostCode:    "1000",
+	}))
+
+	osm := []OSMAddress{
+		{ID: 999, Version: 3, Lat: 46.0, Lon: 14.5, Tags: map[string]string{
+			tagRef:         "111",
+			tagHousenumber: "12",
+			tagStreet:      "Slovenska cesta",
+			tagPostCode:    "1000",
+		}},
+	}
+
+	result := Conflate(gurs, osm)
+
+	seen := make(map[string]bool)
+	for _, f := range result.Update.Features {
+		id, _ := f.Properties["@id"].(string)
+		if seen[id] {
+			t.Fatalf("two distinct GURS features both mapped to %s", id)
+		}
+		seen[id] = true
+	}
+
+	// Feature "222" has nowhere else to go (node 999 is already claimed and
+	// is the only OSM address in range), so it must come out as New rather
+	// than silently overwrite node 999's ref:GURS:HS_MID.
+	if len(result.New.Features) != 1 {
+		t.Fatalf("New = %d features, want 1 (second feature falls through to New)", len(result.New.Features))
+	}
+	if ref, _ := result.New.Features[0].Properties[tagRef].(string); ref != "222" {
+		t.Errorf("New feature ref = %q, want 222", ref)
+	}
+}
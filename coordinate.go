@@ -0,0 +1,22 @@
+package main
+
+// Coordinate is a WGS84 latitude/longitude pair. Keeping it as a single
+// named type means every writer that needs to go from GURS's (lat, lon) to
+// a GeoJSON [lon, lat] Point geometry - or back - does it the same way in
+// one place, instead of each writer guessing the slice order for itself.
+type Coordinate struct {
+	Lat float64
+	Lon float64
+}
+
+// GeoJSONPoint returns c as a [lon, lat] pair, the coordinate order RFC
+// 7946 requires for a GeoJSON Point geometry.
+func (c Coordinate) GeoJSONPoint() []float64 {
+	return []float64{c.Lon, c.Lat}
+}
+
+// CoordinateFromGeoJSONPoint is the inverse of GeoJSONPoint, for code that
+// reads a feature's geometry back out.
+func CoordinateFromGeoJSONPoint(point []float64) Coordinate {
+	return Coordinate{Lon: point[0], Lat: point[1]}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestCoordinateGeoJSONPointRoundTrip(t *testing.T) {
+	coord := Coordinate{Lat: 46.0569, Lon: 14.5058}
+
+	point := coord.GeoJSONPoint()
+	if len(point) != 2 || point[0] != coord.Lon || point[1] != coord.Lat {
+		t.Fatalf("GeoJSONPoint() = %v, want [lon, lat] = [%v, %v]", point, coord.Lon, coord.Lat)
+	}
+
+	got := CoordinateFromGeoJSONPoint(point)
+	if got != coord {
+		t.Fatalf("CoordinateFromGeoJSONPoint(%v) = %v, want %v", point, got, coord)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/paulmach/go.geojson"
+)
+
+const gpxCreator = "gursShp2geoJson"
+
+type gpxWaypoint struct {
+	XMLName xml.Name `xml:"wpt"`
+	Lat     float64  `xml:"lat,attr"`
+	Lon     float64  `xml:"lon,attr"`
+	Name    string   `xml:"name"`
+	Desc    string   `xml:"desc,omitempty"`
+}
+
+type gpxDocument struct {
+	XMLName xml.Name      `xml:"gpx"`
+	Version string        `xml:"version,attr"`
+	Creator string        `xml:"creator,attr"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Wpt     []gpxWaypoint `xml:"wpt"`
+}
+
+// featureToGPXWaypoint converts one GeoJSON feature into a GPX waypoint
+// named "street housenumber" (or "place housenumber"), with postcode, city
+// and ref:GURS:HS_MID carried in <desc> so a surveyor can match the
+// waypoint back to the source record in the field.
+func featureToGPXWaypoint(f *geojson.Feature) gpxWaypoint {
+	coord := CoordinateFromGeoJSONPoint(f.Geometry.Point)
+
+	housenumber, _ := f.Properties[tagHousenumber].(string)
+	street, _ := f.Properties[tagStreet].(string)
+	place, _ := f.Properties[tagPlace].(string)
+	postcode, _ := f.Properties[tagPostCode].(string)
+	city, _ := f.Properties[tagCity].(string)
+	ref, _ := f.Properties[tagRef].(string)
+
+	name := street
+	if name == "" {
+		name = place
+	}
+	name = strings.TrimSpace(name + " " + housenumber)
+
+	return gpxWaypoint{
+		Lat:  coord.Lat,
+		Lon:  coord.Lon,
+		Name: name,
+		Desc: fmt.Sprintf("%s %s, %s", postcode, city, ref),
+	}
+}
+
+// WriteGPX writes fc as a GPX 1.1 waypoint file at filename, for loading
+// into Garmin/OsmAnd/Locus when verifying housenumbers on the ground.
+func WriteGPX(fc *geojson.FeatureCollection, filename string) {
+	doc := gpxDocument{
+		Version: "1.1",
+		Creator: gpxCreator,
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+	}
+	for _, f := range fc.Features {
+		doc.Wpt = append(doc.Wpt, featureToGPXWaypoint(f))
+	}
+
+	rawXML, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	content := []byte(xml.Header + string(rawXML) + "\n")
+	if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Wrote %d waypoints to %s.", len(doc.Wpt), filename)
+}
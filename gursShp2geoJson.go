@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"math"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -16,11 +17,25 @@ import (
 	"github.com/jonas-p/go-shp"
 	"github.com/paulmach/go.geojson"
 	"golang.org/x/text/encoding/charmap"
+
+	"github.com/stefanb/GursAddressesForOSM/address"
+	"github.com/stefanb/GursAddressesForOSM/conflate"
 )
 
 var inputShapeFileName = flag.String("in", "data/temp/HS-etrs89/SI.GURS.RPE.PUB.HS-etrs89.shp", "Input ShapeFile to read")
 var outputGeoJSONFileName = flag.String("out", "data/slovenia-housenumbers.geojson", "Output GeoJSON file to save")
 
+var conflateOSMFileName = flag.String("conflate-osm", "", "Existing OSM extract (.osm.pbf or .osm.xml) to conflate against; when set, -out is ignored and new.geojson/update.geojson/retire.geojson are written to -conflate-out-dir instead")
+var conflateOutDir = flag.String("conflate-out-dir", "data", "Directory to write new.geojson/update.geojson/retire.geojson into when -conflate-osm is set")
+
+var outputOSCFileName = flag.String("osc", "", "Also write an osmChange (.osc) document to this path, ready to upload through JOSM or the OSM API")
+var changesetComment = flag.String("changeset-comment", "Import/update of GURS housenumbers", "Changeset comment written to changeset.osm alongside -osc")
+
+var outputFormat = flag.String("format", "geojson", "Output format for -out: geojson, pb (length-delimited google.type.PostalAddress stream) or gpx (waypoints)")
+
+var splitBy = flag.String("split", "", "Stream output into multiple per-key GeoJSON files instead of a single -out file: postcode, ob_mid, or zoom:N (slippy-map tiles)")
+var splitOutDir = flag.String("split-out-dir", "out", "Directory to write split output files into when -split is set")
+
 // Reads 2 columns from shapeFileName and returns them as a map
 func readShapefileToMap(shapeFileName string, keyColumnName, valueColumnName string) map[string]string {
 	result := make(map[string]string)
@@ -94,6 +109,14 @@ const (
 	tagPostCode    = "addr:postcode"
 	tagStreet      = "addr:street"
 	tagPlace       = "addr:place"
+	tagAddrFull    = "addr:full"
+
+	// tagObMid is not an OSM tag; it carries GURS's OB_MID (municipality id)
+	// through to -split=ob_mid routing. withoutInternalProperties strips it
+	// (and every other "@"-prefixed property) from every GeoJSON writer, so
+	// it never reaches a file a JOSM upload would pick up.
+	tagObMid = "@ob_mid"
+
 	tagSourceDate  = "source:addr:date"
 	tagSource      = "source:addr"
 	tagSourceValue = "GURS"
@@ -150,6 +173,29 @@ func ReadLookups() {
 
 // ReadShapefile reads the given shapefile and returns the geoJson
 func ReadShapefile(shapefilename string) *geojson.FeatureCollection {
+	featureCollection := geojson.NewFeatureCollection()
+	readShapefileInto(shapefilename, func(f *geojson.Feature) { featureCollection.AddFeature(f) })
+	return featureCollection
+}
+
+// ReadShapefileStream reads the given shapefile and sends each valid
+// feature on the returned channel as soon as it's decoded, closing the
+// channel once the file has been fully read. Unlike ReadShapefile, it never
+// holds more than one feature in memory at a time, which matters once
+// -split starts fanning ~600k features out to per-tile Writers.
+func ReadShapefileStream(shapefilename string) <-chan *geojson.Feature {
+	out := make(chan *geojson.Feature)
+	go func() {
+		defer close(out)
+		readShapefileInto(shapefilename, func(f *geojson.Feature) { out <- f })
+	}()
+	return out
+}
+
+// readShapefileInto reads shapefilename and calls emit for every feature
+// that passes validation, the shared reading/decoding logic behind both
+// ReadShapefile and ReadShapefileStream.
+func readShapefileInto(shapefilename string, emit func(*geojson.Feature)) {
 
 	//log.Printf("Reading %s...", shapefilename)
 
@@ -164,7 +210,6 @@ func ReadShapefile(shapefilename string) *geojson.FeatureCollection {
 	// fields from the attribute table (DBF)
 	//	fields := shape.Fields()
 
-	featureCollection := geojson.NewFeatureCollection()
 	//i := 0
 
 	// loop through all features in the shapefile
@@ -197,7 +242,8 @@ func ReadShapefile(shapefilename string) *geojson.FeatureCollection {
 		// prepare rounded coordinates:
 		lat := math.Round(bb.MinY*roundingFactor) / roundingFactor
 		lon := math.Round(bb.MinX*roundingFactor) / roundingFactor
-		f := geojson.NewPointFeature([]float64{lat, lon})
+		coord := Coordinate{Lat: lat, Lon: lon}
+		f := geojson.NewPointFeature(coord.GeoJSONPoint())
 
 		/*
 		   http://www.e-prostor.gov.si/fileadmin/struktura/RPE_struktura.pdf
@@ -219,23 +265,27 @@ func ReadShapefile(shapefilename string) *geojson.FeatureCollection {
 		   14	X_C N 6.0 X koordinata centroida hišne številke
 		*/
 		labela := shapeReader.Attribute(4)
-
-		f.SetProperty(tagHousenumber, strings.ToLower(DecodeWindows1250(labela)))
+		housenumber := strings.ToLower(DecodeWindows1250(labela))
+		f.SetProperty(tagHousenumber, housenumber)
 
 		ulMid := shapeReader.Attribute(5)
 
+		var street, place string
+
 		if ulName, streetNameExists := ulNameMap[ulMid]; streetNameExists {
 			// street name exists
 
 			if ulNameDj, bilingualStreetNameExists := ulNameDjMap[ulMid]; bilingualStreetNameExists && ulNameDj != ulName {
 				// bilingual street name exists
-				f.SetProperty(tagStreet, ulName+bilingualSeparator+ulNameDj)
+				street = ulName + bilingualSeparator + ulNameDj
+				f.SetProperty(tagStreet, street)
 				//f.SetProperty(tagStreet, strings.Join([]string{ulName, bilingualSeparator, ulNameDj}, ""))
 				f.SetProperty(tagStreet+tagLangPostfixSlovenian, ulName)
 				f.SetProperty(ApplyTagLanguagePostfix(tagStreet, lon), ulNameDj)
 			} else {
 				// only slovenian name
-				f.SetProperty(tagStreet, ulName)
+				street = ulName
+				f.SetProperty(tagStreet, street)
 			}
 		} else {
 			// no street name, only place
@@ -244,21 +294,28 @@ func ReadShapefile(shapefilename string) *geojson.FeatureCollection {
 
 			if naNameDj, bilingualPlaceNameExists := naNameDjMap[naMid]; bilingualPlaceNameExists && naNameDj != naName {
 				// bilingual place name exists
-				f.SetProperty(tagPlace, naName+bilingualSeparator+naNameDj)
+				place = naName + bilingualSeparator + naNameDj
+				f.SetProperty(tagPlace, place)
 				//f.SetProperty(tagStreet, strings.Join([]string{naName, bilingualSeparator, naNameDj}, ""))
 				f.SetProperty(tagPlace+tagLangPostfixSlovenian, naName)
 				f.SetProperty(ApplyTagLanguagePostfix(tagPlace, lon), naNameDj)
 			} else {
 				// only slovenian name
-				f.SetProperty(tagPlace, naName)
+				place = naName
+				f.SetProperty(tagPlace, place)
 			}
 
 		}
 
+		obMid := shapeReader.Attribute(7)
+		f.SetProperty(tagObMid, obMid)
+
 		ptMid := shapeReader.Attribute(8)
-		f.SetProperty(tagPostCode, ptCodeMap[ptMid])
+		postcode := ptCodeMap[ptMid]
+		f.SetProperty(tagPostCode, postcode)
 
-		f.SetProperty(tagCity, ptNameMap[ptMid])
+		city := ptNameMap[ptMid]
+		f.SetProperty(tagCity, city)
 
 		dateOd := shapeReader.Attribute(10)
 		// slice it up into nice iso YYYY-MM-DD format:
@@ -270,10 +327,15 @@ func ReadShapefile(shapefilename string) *geojson.FeatureCollection {
 		hsMid := shapeReader.Attribute(1)
 		f.SetProperty(tagRef, hsMid)
 
-		featureCollection.AddFeature(f)
-	}
+		record := address.Record{Housenumber: housenumber, Street: street, Place: place, Postcode: postcode, City: city}
+		if reason := address.Validate("SI", record); reason != "" {
+			log.Printf("skipping %s: %s", hsMid, reason)
+			continue
+		}
+		f.SetProperty(tagAddrFull, address.RenderLayout(address.SelectLocalLayout(), record))
 
-	return featureCollection
+		emit(f)
+	}
 }
 
 // SortFeatureCollection sorts the Features of the given FeatureCollection for reproducable results and better compression
@@ -356,24 +418,110 @@ func main() {
 	ReadLookups()
 	log.Printf("Reading %s...", *inputShapeFileName)
 
+	if *splitBy != "" {
+		runSplit()
+		return
+	}
+
 	featureCollection := ReadShapefile(*inputShapeFileName)
 
 	log.Printf("Sorting %d features...", len(featureCollection.Features))
 	SortFeatureCollection(*featureCollection)
 
+	if *conflateOSMFileName != "" {
+		runConflate(featureCollection)
+		return
+	}
+
+	switch *outputFormat {
+	case "pb":
+		WritePostalAddressStream(featureCollection, *outputGeoJSONFileName)
+	case "gpx":
+		WriteGPX(featureCollection, *outputGeoJSONFileName)
+	default:
+		writeGeoJSON(featureCollection, *outputGeoJSONFileName)
+		log.Printf("Saved %d addresses to %s.", len(featureCollection.Features), *outputGeoJSONFileName)
+	}
+
+	if *outputOSCFileName != "" {
+		writeOSC(featureCollection, nil, nil)
+	}
+}
+
+// writeOSC writes -osc (and its accompanying changeset.osm) for the given
+// feature buckets. updateFeatures/retireFeatures are nil for a plain
+// export and populated when called after a conflation run.
+func writeOSC(newFeatures, updateFeatures, retireFeatures *geojson.FeatureCollection) {
+	log.Printf("Writing osmChange to %s...", *outputOSCFileName)
+	WriteOSMChange(newFeatures, updateFeatures, retireFeatures, *outputOSCFileName)
+
+	changesetFileName := filepath.Join(filepath.Dir(*outputOSCFileName), "changeset.osm")
+	writeChangesetOSM(*changesetComment, changesetFileName)
+}
+
+// runSplit streams the input shapefile straight to per-key Writers instead
+// of building a single in-memory FeatureCollection, see -split.
+func runSplit() {
+	router := newSplitRouter(*splitBy, *splitOutDir)
+
+	count := 0
+	for f := range ReadShapefileStream(*inputShapeFileName) {
+		router.route(f)
+		count++
+	}
+	router.close()
+
+	log.Printf("Saved %d addresses split by %s under %s.", count, *splitBy, *splitOutDir)
+}
+
+// writeGeoJSON marshals the given FeatureCollection and writes it to filename.
+func writeGeoJSON(featureCollection *geojson.FeatureCollection, filename string) {
+	stripped := geojson.NewFeatureCollection()
+	for _, f := range featureCollection.Features {
+		stripped.AddFeature(withoutInternalProperties(f))
+	}
+
 	//rawJSON, err := featureCollection.MarshalJSON()
-	rawJSON, err := json.MarshalIndent(featureCollection, "", "  ")
+	rawJSON, err := json.MarshalIndent(stripped, "", "  ")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	err = ioutil.WriteFile(*outputGeoJSONFileName, rawJSON, 0644)
+	err = ioutil.WriteFile(filename, rawJSON, 0644)
 	if err != nil {
 		log.Fatal(err)
 	}
+}
 
-	log.Printf("Saved %d addresses to %s.", len(featureCollection.Features), *outputGeoJSONFileName)
+// runConflate matches the freshly-read GURS features against the OSM
+// extract named by -conflate-osm and writes new.geojson, update.geojson
+// and retire.geojson to -conflate-out-dir, instead of a plain export.
+func runConflate(featureCollection *geojson.FeatureCollection) {
+	log.Printf("Reading OSM extract %s...", *conflateOSMFileName)
 
+	var osmAddresses []conflate.OSMAddress
+	if strings.HasSuffix(*conflateOSMFileName, ".pbf") {
+		osmAddresses = conflate.LoadOSMPBF(*conflateOSMFileName)
+	} else {
+		osmAddresses = conflate.LoadOSMXML(*conflateOSMFileName)
+	}
+
+	log.Printf("Conflating %d GURS addresses against %d OSM addresses...", len(featureCollection.Features), len(osmAddresses))
+	result := conflate.Conflate(featureCollection, osmAddresses)
+
+	newFileName := filepath.Join(*conflateOutDir, "new.geojson")
+	updateFileName := filepath.Join(*conflateOutDir, "update.geojson")
+	retireFileName := filepath.Join(*conflateOutDir, "retire.geojson")
+
+	writeGeoJSON(result.New, newFileName)
+	writeGeoJSON(result.Update, updateFileName)
+	writeGeoJSON(result.Retire, retireFileName)
+
+	log.Printf("Saved %d new, %d updated, %d retired addresses to %s.", len(result.New.Features), len(result.Update.Features), len(result.Retire.Features), *conflateOutDir)
+
+	if *outputOSCFileName != "" {
+		writeOSC(result.New, result.Update, result.Retire)
+	}
 }
 
 // DecodeWindows1250bytes decodes win1250 []byte and returns UTF-8 string
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// osmChangeTag is a single key/value OSM tag.
+type osmChangeTag struct {
+	K string `xml:"k,attr"`
+	V string `xml:"v,attr"`
+}
+
+// osmChangeNode is a <node> as it appears inside a <create>/<modify>/<delete>
+// block of an osmChange document.
+type osmChangeNode struct {
+	XMLName xml.Name       `xml:"node"`
+	ID      int64          `xml:"id,attr"`
+	Lat     float64        `xml:"lat,attr"`
+	Lon     float64        `xml:"lon,attr"`
+	Version int            `xml:"version,attr,omitempty"`
+	Tag     []osmChangeTag `xml:"tag"`
+}
+
+// osmChangeBlock wraps the nodes for one of <create>/<modify>/<delete>.
+type osmChangeBlock struct {
+	Node []osmChangeNode `xml:"node"`
+}
+
+// osmChange is the root element of an osmChange (.osc) document, see
+// https://wiki.openstreetmap.org/wiki/OsmChange
+type osmChange struct {
+	XMLName   xml.Name        `xml:"osmChange"`
+	Version   string          `xml:"version,attr"`
+	Generator string          `xml:"generator,attr"`
+	Create    *osmChangeBlock `xml:"create,omitempty"`
+	Modify    *osmChangeBlock `xml:"modify,omitempty"`
+	Delete    *osmChangeBlock `xml:"delete,omitempty"`
+}
+
+const osmChangeGenerator = "gursShp2geoJson"
+
+// featureToOSMChangeNode converts a GeoJSON feature (as produced by
+// ReadShapefile or conflate.Conflate) into an osmChange node. id is used
+// verbatim; callers pass a negative placeholder id for new nodes, or the
+// existing OSM node id (parsed out of the "@id" property) for modified or
+// deleted ones. version is the node's current OSM version for modify/delete
+// (the API rejects those when it doesn't match); callers pass 0 for new
+// nodes, which don't have one yet.
+func featureToOSMChangeNode(f *geojson.Feature, id int64, version int) osmChangeNode {
+	coord := CoordinateFromGeoJSONPoint(f.Geometry.Point)
+	lat, lon := coord.Lat, coord.Lon
+
+	node := osmChangeNode{ID: id, Lat: lat, Lon: lon, Version: version}
+	for k, v := range f.Properties {
+		if strings.HasPrefix(k, internalPropertyPrefix) {
+			// internalPropertyPrefix-marked properties (e.g. "@id",
+			// "@version", "@ob_mid") are internal bookkeeping, not OSM tags
+			// to upload.
+			continue
+		}
+		str, ok := v.(string)
+		if !ok {
+			str = fmt.Sprintf("%v", v)
+		}
+		node.Tag = append(node.Tag, osmChangeTag{K: k, V: str})
+	}
+	return node
+}
+
+// osmNodeIDFromFeature extracts the existing node id conflate.Conflate
+// stashed in the "@id" property (formatted as "node/<id>"), for use in
+// <modify>/<delete> blocks.
+func osmNodeIDFromFeature(f *geojson.Feature) (int64, bool) {
+	raw, ok := f.Properties["@id"].(string)
+	if !ok {
+		return 0, false
+	}
+	var id int64
+	if _, err := fmt.Sscanf(strings.TrimPrefix(raw, "node/"), "%d", &id); err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// osmNodeVersionFromFeature extracts the node's current OSM version,
+// stashed by conflate.Conflate in the "@version" property, for use in
+// <modify>/<delete> blocks.
+func osmNodeVersionFromFeature(f *geojson.Feature) (int, bool) {
+	switch version := f.Properties["@version"].(type) {
+	case int:
+		return version, true
+	case float64:
+		// properties decoded from JSON hold numbers as float64.
+		return int(version), true
+	default:
+		return 0, false
+	}
+}
+
+// WriteOSMChange serializes newFeatures/updateFeatures/retireFeatures as an
+// osmChange document at filename, with <create> nodes getting decreasing
+// negative placeholder ids as required by the OSM API and JOSM. Either of
+// updateFeatures/retireFeatures may be nil when no conflation was run, in
+// which case the document only contains a <create> block.
+func WriteOSMChange(newFeatures, updateFeatures, retireFeatures *geojson.FeatureCollection, filename string) {
+	change := osmChange{Version: "0.6", Generator: osmChangeGenerator}
+
+	if newFeatures != nil && len(newFeatures.Features) > 0 {
+		block := &osmChangeBlock{}
+		placeholderID := int64(-1)
+		for _, f := range newFeatures.Features {
+			block.Node = append(block.Node, featureToOSMChangeNode(f, placeholderID, 0))
+			placeholderID--
+		}
+		change.Create = block
+	}
+
+	if updateFeatures != nil && len(updateFeatures.Features) > 0 {
+		block := &osmChangeBlock{}
+		for _, f := range updateFeatures.Features {
+			id, ok := osmNodeIDFromFeature(f)
+			if !ok {
+				log.Printf("WARNING: update feature without @id, skipping: %v", f.Properties)
+				continue
+			}
+			version, ok := osmNodeVersionFromFeature(f)
+			if !ok {
+				log.Printf("WARNING: update feature without @version, skipping: %v", f.Properties)
+				continue
+			}
+			block.Node = append(block.Node, featureToOSMChangeNode(f, id, version))
+		}
+		change.Modify = block
+	}
+
+	if retireFeatures != nil && len(retireFeatures.Features) > 0 {
+		block := &osmChangeBlock{}
+		for _, f := range retireFeatures.Features {
+			id, ok := osmNodeIDFromFeature(f)
+			if !ok {
+				log.Printf("WARNING: retire feature without @id, skipping: %v", f.Properties)
+				continue
+			}
+			version, ok := osmNodeVersionFromFeature(f)
+			if !ok {
+				log.Printf("WARNING: retire feature without @version, skipping: %v", f.Properties)
+				continue
+			}
+			block.Node = append(block.Node, featureToOSMChangeNode(f, id, version))
+		}
+		change.Delete = block
+	}
+
+	rawXML, err := xml.MarshalIndent(change, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	content := []byte(xml.Header + string(rawXML) + "\n")
+	if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// writeChangesetOSM writes the small changeset.osm metadata file that JOSM's
+// "Upload to OSM API" expects alongside an osmChange document.
+func writeChangesetOSM(comment, filename string) {
+	type changesetTag struct {
+		K string `xml:"k,attr"`
+		V string `xml:"v,attr"`
+	}
+	type changeset struct {
+		XMLName xml.Name       `xml:"osm"`
+		Version string         `xml:"version,attr"`
+		Tag     []changesetTag `xml:"changeset>tag"`
+	}
+
+	cs := changeset{
+		Version: "0.6",
+		Tag: []changesetTag{
+			{K: "created_by", V: osmChangeGenerator},
+			{K: "comment", V: comment},
+			{K: "source", V: tagSourceValue},
+			{K: "date", V: time.Now().Format("2006-01-02")},
+		},
+	}
+
+	rawXML, err := xml.MarshalIndent(cs, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	content := []byte(xml.Header + string(rawXML) + "\n")
+	if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/paulmach/go.geojson"
+	"google.golang.org/genproto/googleapis/type/postaladdress"
+)
+
+const (
+	// PostalAddress is revision 0 as of this writing, see
+	// google.golang.org/genproto/googleapis/type/postaladdress.
+	postalAddressRevision = 0
+	regionCodeSlovenia    = "SI"
+)
+
+// featureToPostalAddresses converts one GeoJSON feature into one or more
+// google.type.PostalAddress messages: the primary Slovenian rendering, plus
+// one extra message per bilingual variant (the ":it"/":hu" tags ReadShapefile
+// sets for border-area street and place names) present on the feature.
+func featureToPostalAddresses(f *geojson.Feature) []*postaladdress.PostalAddress {
+	postcode, _ := f.Properties[tagPostCode].(string)
+	city, _ := f.Properties[tagCity].(string)
+	housenumber, _ := f.Properties[tagHousenumber].(string)
+
+	var addresses []*postaladdress.PostalAddress
+	if line := addressLine(f, housenumber, ""); line != "" {
+		addresses = append(addresses, newPostalAddress("sl", postcode, city, line))
+	}
+
+	for _, postfix := range []string{tagLangPostfixItalian, tagLangPostfixHungarian} {
+		if line := addressLine(f, housenumber, postfix); line != "" {
+			addresses = append(addresses, newPostalAddress(strings.TrimPrefix(postfix, ":"), postcode, city, line))
+		}
+	}
+
+	return addresses
+}
+
+func newPostalAddress(languageCode, postcode, locality, addressLine string) *postaladdress.PostalAddress {
+	return &postaladdress.PostalAddress{
+		Revision:     postalAddressRevision,
+		RegionCode:   regionCodeSlovenia,
+		LanguageCode: languageCode,
+		PostalCode:   postcode,
+		Locality:     locality,
+		AddressLines: []string{addressLine},
+	}
+}
+
+// addressLine composes "street housenumber" or "place housenumber" for the
+// given tag postfix (empty string for the default, Slovenian-language
+// line). ReadShapefile sets the plain addr:street/addr:place tag to the
+// combined "sl / it" bilingual string on border-municipality records, with
+// the pure Slovenian name broken out separately under the ":sl" postfix, so
+// the default line must prefer that ":sl" tag and only fall back to the
+// plain tag for non-bilingual records where ":sl" was never set.
+func addressLine(f *geojson.Feature, housenumber, tagPostfix string) string {
+	streetTag, placeTag := tagStreet+tagPostfix, tagPlace+tagPostfix
+	if tagPostfix == "" {
+		streetTag, placeTag = tagStreet+tagLangPostfixSlovenian, tagPlace+tagLangPostfixSlovenian
+	}
+
+	street, _ := f.Properties[streetTag].(string)
+	if street == "" && tagPostfix == "" {
+		street, _ = f.Properties[tagStreet].(string)
+	}
+	place, _ := f.Properties[placeTag].(string)
+	if place == "" && tagPostfix == "" {
+		place, _ = f.Properties[tagPlace].(string)
+	}
+
+	switch {
+	case street != "":
+		return street + " " + housenumber
+	case place != "":
+		return place + " " + housenumber
+	default:
+		return ""
+	}
+}
+
+// WritePostalAddressStream writes each feature of fc as one or more
+// length-delimited google.type.PostalAddress messages - a varint-prefixed
+// frame per message, in the spirit of pprof's framed protobuf profile
+// format - so downstream geocoding pipelines can decode records one at a
+// time without loading the whole file into memory.
+func WritePostalAddressStream(fc *geojson.FeatureCollection, filename string) {
+	out, err := os.Create(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer out.Close()
+
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	var lengthBuf [binary.MaxVarintLen64]byte
+	count := 0
+	for _, feature := range fc.Features {
+		for _, addr := range featureToPostalAddresses(feature) {
+			raw, err := proto.Marshal(addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			n := binary.PutUvarint(lengthBuf[:], uint64(len(raw)))
+			if _, err := w.Write(lengthBuf[:n]); err != nil {
+				log.Fatal(err)
+			}
+			if _, err := w.Write(raw); err != nil {
+				log.Fatal(err)
+			}
+			count++
+		}
+	}
+
+	log.Printf("Wrote %d postal address messages to %s.", count, filename)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// Writer accepts GeoJSON features one at a time and flushes them to their
+// destination on Close.
+type Writer interface {
+	Write(f *geojson.Feature) error
+	Close() error
+}
+
+// internalPropertyPrefix marks feature properties that exist purely for
+// this tool's own bookkeeping (e.g. "@id", "@version", "@ob_mid") and must
+// never reach a GeoJSON file a mapper might load into JOSM.
+const internalPropertyPrefix = "@"
+
+// withoutInternalProperties returns f unchanged if it carries no
+// internalPropertyPrefix-prefixed properties, otherwise a shallow copy with
+// those properties stripped.
+func withoutInternalProperties(f *geojson.Feature) *geojson.Feature {
+	hasInternal := false
+	for k := range f.Properties {
+		if strings.HasPrefix(k, internalPropertyPrefix) {
+			hasInternal = true
+			break
+		}
+	}
+	if !hasInternal {
+		return f
+	}
+
+	clone := *f
+	clone.Properties = make(map[string]interface{}, len(f.Properties))
+	for k, v := range f.Properties {
+		if !strings.HasPrefix(k, internalPropertyPrefix) {
+			clone.Properties[k] = v
+		}
+	}
+	return &clone
+}
+
+// streamingGeoJSONWriter writes a FeatureCollection to a file incrementally,
+// one feature at a time, so routing ~600k features into many small tiles
+// never requires holding more than one feature's worth of JSON in memory.
+type streamingGeoJSONWriter struct {
+	file  *os.File
+	wrote bool
+}
+
+func newStreamingGeoJSONWriter(filename string) (*streamingGeoJSONWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := f.WriteString(`{"type":"FeatureCollection","features":[`); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &streamingGeoJSONWriter{file: f}, nil
+}
+
+func (w *streamingGeoJSONWriter) Write(f *geojson.Feature) error {
+	raw, err := json.Marshal(withoutInternalProperties(f))
+	if err != nil {
+		return err
+	}
+
+	if w.wrote {
+		if _, err := w.file.WriteString(","); err != nil {
+			return err
+		}
+	}
+	w.wrote = true
+
+	_, err = w.file.Write(raw)
+	return err
+}
+
+func (w *streamingGeoJSONWriter) Close() error {
+	if _, err := w.file.WriteString("]}"); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// splitRouter sends each feature to the Writer for its split key, opening a
+// new output file under outDir the first time a key is seen.
+type splitRouter struct {
+	keyFunc func(*geojson.Feature) string
+	outDir  string
+	writers map[string]Writer
+}
+
+// newSplitRouter builds a router for -split values "postcode", "ob_mid" or
+// "zoom:N", writing one file per key under outDir.
+func newSplitRouter(split, outDir string) *splitRouter {
+	return &splitRouter{
+		keyFunc: splitKeyFunc(split),
+		outDir:  outDir,
+		writers: make(map[string]Writer),
+	}
+}
+
+func (r *splitRouter) route(f *geojson.Feature) {
+	key := r.keyFunc(f)
+
+	w, ok := r.writers[key]
+	if !ok {
+		var err error
+		w, err = newStreamingGeoJSONWriter(filepath.Join(r.outDir, key+".geojson"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.writers[key] = w
+	}
+
+	if err := w.Write(f); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (r *splitRouter) close() {
+	for _, w := range r.writers {
+		if err := w.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// splitKeyFunc parses a -split value into the function used to compute each
+// feature's output key.
+func splitKeyFunc(split string) func(*geojson.Feature) string {
+	switch {
+	case split == "postcode":
+		return func(f *geojson.Feature) string {
+			postcode, _ := f.Properties[tagPostCode].(string)
+			return postcode
+		}
+
+	case split == "ob_mid":
+		return func(f *geojson.Feature) string {
+			obMid, _ := f.Properties[tagObMid].(string)
+			return obMid
+		}
+
+	case strings.HasPrefix(split, "zoom:"):
+		zoom, err := strconv.Atoi(strings.TrimPrefix(split, "zoom:"))
+		if err != nil {
+			log.Fatalf("invalid -split value %q: %v", split, err)
+		}
+		return func(f *geojson.Feature) string {
+			coord := CoordinateFromGeoJSONPoint(f.Geometry.Point)
+			x, y := slippyTileXY(coord.Lat, coord.Lon, zoom)
+			return fmt.Sprintf("%d/%d/%d", zoom, x, y)
+		}
+
+	default:
+		log.Fatalf("unknown -split value %q, want postcode, ob_mid or zoom:N", split)
+		return nil
+	}
+}
+
+// slippyTileXY returns the slippy-map (OSM/Google) tile x/y containing
+// (lat, lon) at the given zoom level.
+// See https://wiki.openstreetmap.org/wiki/Slippy_map_tilenames
+func slippyTileXY(lat, lon float64, zoom int) (int, int) {
+	n := math.Exp2(float64(zoom))
+	x := int((lon + 180.0) / 360.0 * n)
+
+	latRad := lat * math.Pi / 180.0
+	y := int((1.0 - math.Log(math.Tan(latRad)+1.0/math.Cos(latRad))/math.Pi) / 2.0 * n)
+
+	return x, y
+}
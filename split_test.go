@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestSlippyTileXY(t *testing.T) {
+	// Ljubljana city center, a value easy to sanity-check against any
+	// slippy-map tile viewer.
+	lat, lon := 46.0569, 14.5058
+
+	x, y := slippyTileXY(lat, lon, 0)
+	if x != 0 || y != 0 {
+		t.Errorf("slippyTileXY(%v, %v, 0) = (%d, %d), want (0, 0)", lat, lon, x, y)
+	}
+
+	x, y = slippyTileXY(lat, lon, 10)
+	if x != 553 || y != 364 {
+		t.Errorf("slippyTileXY(%v, %v, 10) = (%d, %d), want (553, 364)", lat, lon, x, y)
+	}
+
+	// One degree further east on the same latitude lands in a tile with a
+	// larger x.
+	xEast, _ := slippyTileXY(lat, lon+1, 10)
+	if xEast <= x {
+		t.Errorf("slippyTileXY moving east should increase x: got %d, want > %d", xEast, x)
+	}
+}